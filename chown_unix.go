@@ -0,0 +1,22 @@
+//go:build !windows
+
+package gzlog
+
+import (
+	"os"
+	"syscall"
+)
+
+// statOwner returns the uid/gid of fi, if the platform's os.FileInfo.Sys() exposes one.
+func statOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+// chown sets the owner and group of name.
+func chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package gzlog
+
+import "os"
+
+// statOwner is a no-op on Windows, which has no POSIX uid/gid to preserve.
+func statOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// chown is a no-op on Windows.
+func chown(name string, uid, gid int) error {
+	return nil
+}
@@ -0,0 +1,232 @@
+package gzlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// File is the subset of *os.File that GzLog needs from whatever FS produced it.
+type File interface {
+	io.Writer
+	io.ReaderAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// FS abstracts the filesystem operations GzLog needs, so it can run against something
+// other than the local disk -- afero.Fs, a testing/fstest-style in-memory backend, or an
+// S3/GCS-backed filesystem -- and so tests can run without touching disk. OSFS is the
+// default implementation, used by OpenFile and ImportFile.
+type FS interface {
+	Open(name string) (io.ReadCloser, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Create(name string) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	ReadDir(name string) ([]os.DirEntry, error)
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// OSFS implements FS on top of the local filesystem via the os package.
+type OSFS struct{}
+
+func (OSFS) Open(name string) (io.ReadCloser, error) { return os.Open(name) }
+
+func (OSFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (OSFS) Create(name string) (File, error) { return os.Create(name) }
+
+func (OSFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+func (OSFS) Rename(oldname, newname string) error { return os.Rename(oldname, newname) }
+
+func (OSFS) Remove(name string) error { return os.Remove(name) }
+
+func (OSFS) ReadDir(name string) ([]os.DirEntry, error) { return os.ReadDir(name) }
+
+func (OSFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+
+// Compressor defines how rotated backups are archived. GzipCompressor (the default) gzips
+// the backup; NoopCompressor leaves it as-is. Register a codec of your own (zstd, snappy,
+// ...) by implementing this interface and setting it as GzLog.Compressor.
+type Compressor interface {
+	// Extension is the suffix compressFile appends to an archived backup's filename, e.g.
+	// ".gz". Return "" for a format that doesn't add one.
+	Extension() string
+	// NewWriter wraps w so that bytes written to the result are encoded for this
+	// compressor before reaching w.
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+// GzipCompressor is the default Compressor, archiving backups as basename.N.log.gz.
+type GzipCompressor struct{}
+
+func (GzipCompressor) Extension() string { return ".gz" }
+
+func (GzipCompressor) NewWriter(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+// NoopCompressor archives backups without compressing them; Extension returns "", so a
+// rotated basename.N.log is already in its final archived form once getSuitableFile's scan
+// or the maintenance worker passes over it.
+type NoopCompressor struct{}
+
+func (NoopCompressor) Extension() string { return "" }
+
+func (NoopCompressor) NewWriter(w io.Writer) io.WriteCloser { return nopWriteCloser{w} }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func compressFile(fsys FS, fn string, mode os.FileMode, c Compressor) error {
+	ext := c.Extension()
+	if ext == "" {
+		// A zero-extension Compressor (e.g. NoopCompressor) leaves fn already in its
+		// final archived form, so there's nothing to write; archivePath would equal fn
+		// itself, which would otherwise alias the read and the write onto the same file.
+		return nil
+	}
+	archivePath := fn + ext
+	file, err := fsys.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE, mode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rc, err := fsys.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	ba, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+
+	zw := c.NewWriter(file)
+	if gzw, ok := zw.(*gzip.Writer); ok {
+		gzw.Comment = archiveComment(ba)
+	}
+	defer zw.Close()
+
+	_, err = zw.Write(ba)
+	return err
+}
+
+// archiveComment builds the gzip header comment compressFile embeds in each archive: the
+// timestamps of its first and last log lines and its uncompressed size. RangeReader reads
+// this back to skip whole archives without decompressing them.
+func archiveComment(ba []byte) string {
+	first, last, ok := logTimeRange(ba)
+	if !ok {
+		return fmt.Sprintf("size=%d", len(ba))
+	}
+	return fmt.Sprintf("first=%s;last=%s;size=%d", first.Format(time.RFC3339Nano), last.Format(time.RFC3339Nano), len(ba))
+}
+
+// logTimeRange scans ba for logTimeFmt-prefixed lines and returns the timestamps of the
+// first and last ones found.
+func logTimeRange(ba []byte) (first, last time.Time, ok bool) {
+	for _, line := range bytes.Split(bytes.TrimRight(ba, "\n"), []byte("\n")) {
+		t, lineOK := parseLineTime(string(line))
+		if !lineOK {
+			continue
+		}
+		if !ok {
+			first = t
+		}
+		last = t
+		ok = true
+	}
+	return
+}
+
+// parseArchiveComment parses the gzip header comment written by archiveComment.
+func parseArchiveComment(comment string) (first, last time.Time, ok bool) {
+	var firstOK, lastOK bool
+	for _, field := range strings.Split(comment, ";") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "first":
+			if t, err := time.Parse(time.RFC3339Nano, kv[1]); err == nil {
+				first, firstOK = t, true
+			}
+		case "last":
+			if t, err := time.Parse(time.RFC3339Nano, kv[1]); err == nil {
+				last, lastOK = t, true
+			}
+		}
+	}
+	return first, last, firstOK && lastOK
+}
+
+// parseLineTime parses the logTimeFmt prefix off the start of a log line.
+func parseLineTime(line string) (time.Time, bool) {
+	if len(line) < len(logTimeFmt) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(logTimeFmt, line[:len(logTimeFmt)])
+	return t, err == nil
+}
+
+func exists(fsys FS, fn string) bool {
+	_, err := fsys.Stat(fn)
+	return err == nil || !os.IsNotExist(err)
+}
+
+func getSuitableFile(fsys FS, basename string, maxSize int, mode os.FileMode, c Compressor) (string, error) {
+	if maxSize < 0 {
+		return "", ErrInvalidSize
+	}
+	ext := c.Extension()
+	num := 0
+	fn := basename + ".log"
+	for {
+		fi, err := fsys.Stat(fn)
+		if err != nil {
+			// file doesn't exist, use it.
+			return fn, nil
+		}
+
+		if strings.HasSuffix(fn, ext) && ext != "" {
+			// file is an archive, moving on
+			continue
+		}
+		if fi.Size() < int64(maxSize) || maxSize == 0 {
+			// file isn't too big, use this
+			break
+		}
+		if exists(fsys, fn+ext) {
+			// file is already archived
+		} else {
+			// file is too big but hasn't been archived yet. Archive it and move on
+			compressFile(fsys, fn, mode, c)
+		}
+		num++
+		fn = fmt.Sprintf(fnFmt, basename, num)
+	}
+	return fn, nil
+}
+
+// suitableFilename returns the path OpenFile/ImportFile should use for the active log
+// file. NumericSuffix reuses getSuitableFile's counter scan; Timestamp always starts at
+// basename.log, since rotation is what introduces the timestamp in its name.
+func suitableFilename(fsys FS, basename string, maxSize int, mode os.FileMode, layout Layout, c Compressor) (string, error) {
+	if layout == Timestamp {
+		return basename + ".log", nil
+	}
+	return getSuitableFile(fsys, basename, maxSize, mode, c)
+}
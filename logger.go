@@ -1,22 +1,38 @@
 package gzlog
 
 import (
-	"compress/gzip"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	fnFmt      = "%s.%d.log"
+	fnTimeFmt  = "%s-%s.log"
+	timeLayout = "2006-01-02T15-04-05.000"
 	fileFlags  = os.O_CREATE | os.O_APPEND | os.O_RDWR
 	logTimeFmt = "2006/01/02 15:04:05 "
 )
 
+// Layout selects the filename scheme GzLog uses for rotated backups.
+type Layout int
+
+const (
+	// NumericSuffix names backups basename.N.log / basename.N.log.gz, counting up from
+	// the oldest (the default and original behavior).
+	NumericSuffix Layout = iota
+	// Timestamp names backups basename-<timestamp>.log / basename-<timestamp>.log.gz
+	// instead, so the rotation time can be read straight off the filename. This is what
+	// lets RotateInterval and MaxAge implement daily/hourly rotation without a size limit.
+	Timestamp
+)
+
 var (
 	ErrInvalidSize = errors.New("log size must be a positive number")
 )
@@ -26,87 +42,275 @@ func getPrefix() string {
 	return prefix
 }
 
-func gzipFile(fn string, mode os.FileMode) error {
-	gzPath := fn + ".gz"
-	file, err := os.OpenFile(gzPath, os.O_WRONLY|os.O_CREATE, mode)
-	if err != nil {
-		return err
+// GzLog is a logging tool for writing text to log files and automatically compressing and
+// rotating them if the current log file is larger than the maxSize before writing to it
+// to avoid having huge log files that can be tedious to use for debugging
+type GzLog struct {
+	basename     string
+	filename     string
+	file         File
+	stat         os.FileInfo
+	maxSize      int64
+	externalFile bool
+	fs           FS
+
+	// MaxBackups is the maximum number of compressed backups to keep around. Once there
+	// are more than MaxBackups archives, the background maintenance worker removes the
+	// oldest ones. Zero (the default) keeps every archive.
+	MaxBackups int
+	// MaxAge prunes archives older than this duration, in addition to MaxBackups. Zero
+	// (the default) disables age-based pruning.
+	MaxAge time.Duration
+	// RotateInterval, if non-zero, forces a rotation once the current file has been open
+	// for at least this long, regardless of MaxSize. Only the Timestamp layout starts a new
+	// file on such a rotation; under NumericSuffix the same file is reopened unless it has
+	// also reached MaxSize.
+	RotateInterval time.Duration
+	// Layout selects the filename scheme used for rotated backups.
+	Layout Layout
+	// MakeDecoderFn, if set, is used by TailReader/RangeReader to decode backups that
+	// aren't in gzlog's own plain-text format.
+	MakeDecoderFn MakeDecoderFn
+	// Compressor selects how rotated backups are archived. Nil (the default) uses
+	// GzipCompressor.
+	Compressor Compressor
+
+	openedAt time.Time
+
+	mu        sync.Mutex
+	millOnce  sync.Once
+	millCh    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// closeFile closes the underlying file unless it was created elsewhere and imported
+// with ImportFile. Unlike Close, it doesn't touch the maintenance worker, so rotate can
+// use it to swap files without tearing down the background goroutine.
+func (gl *GzLog) closeFile() error {
+	if gl.file == nil || gl.file == os.Stdout || gl.file == os.Stderr || gl.externalFile {
+		return nil
 	}
-	defer file.Close()
+	return gl.file.Close()
+}
 
-	ba, err := ioutil.ReadFile(fn)
-	if err != nil {
-		return err
+// compressor returns the active Compressor, defaulting to GzipCompressor when none is set.
+func (gl *GzLog) compressor() Compressor {
+	if gl.Compressor != nil {
+		return gl.Compressor
 	}
+	return GzipCompressor{}
+}
 
-	zw := gzip.NewWriter(file)
-	defer zw.Close()
+// Close cleans up the log file unless the file was created elsewhere and imported with
+// ImportFile. If the background maintenance worker was started, Close stops it and waits
+// for it to exit before returning, so no goroutine outlives the GzLog. Close is safe to
+// call more than once; only the first call does anything.
+func (gl *GzLog) Close() error {
+	var err error
+	gl.closeOnce.Do(func() {
+		// maintenance creates millCh/done and sends on millCh under gl.mu, so Close
+		// must take the same lock before touching them -- otherwise a concurrent
+		// Write triggering the first-ever rotation can race with Close and send on
+		// (or read) a channel Close has already closed. The channel is closed while
+		// still holding gl.mu so the two can't interleave; the lock is released
+		// before waiting on done, since millRunOnce needs gl.mu to finish.
+		gl.mu.Lock()
+		millCh, done := gl.millCh, gl.done
+		if millCh != nil {
+			close(millCh)
+		}
+		gl.mu.Unlock()
+		if done != nil {
+			<-done
+		}
 
-	_, err = zw.Write(ba)
+		gl.mu.Lock()
+		defer gl.mu.Unlock()
+		err = gl.closeFile()
+	})
 	return err
 }
 
-func exists(fn string) bool {
-	_, err := os.Stat(fn)
-	return err == nil || !os.IsNotExist(err)
+// maintenance lazily starts the background worker that compresses stray log files and
+// enforces MaxBackups/MaxAge, then pings it to run. It is safe to call on every rotation.
+func (gl *GzLog) maintenance() {
+	gl.millOnce.Do(func() {
+		gl.millCh = make(chan struct{}, 1)
+		gl.done = make(chan struct{})
+		go gl.millRun()
+	})
+	select {
+	case gl.millCh <- struct{}{}:
+	default:
+		// a run is already pending, no need to queue another
+	}
 }
 
-func getSuitableFile(basename string, maxSize int, mode os.FileMode) (string, error) {
-	if maxSize < 0 {
-		return "", ErrInvalidSize
+// millRun is the background maintenance worker started by maintenance. It runs until
+// millCh is closed by Close, then closes done so Close can return.
+func (gl *GzLog) millRun() {
+	defer close(gl.done)
+	for range gl.millCh {
+		millRunOnce(gl)
 	}
-	num := 0
-	fn := basename + ".log"
-	for {
-		fi, err := os.Stat(fn)
-		if err != nil {
-			// file doesn't exist, use it.
-			return fn, nil
+}
+
+// millRunOnce compresses any rotated log files that haven't been gzipped yet, then
+// removes archives beyond MaxBackups. Errors are swallowed since this runs in the
+// background well after the rotation that triggered it has already succeeded.
+func millRunOnce(gl *GzLog) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+
+	if err := gl.compressPending(); err != nil {
+		return
+	}
+	gl.removeOldBackups()
+}
+
+// globBackups lists the backups in basename's directory that match the active Layout's
+// naming scheme and have the given extension (".log" or ".log.gz"). Callers must hold
+// gl.mu.
+func (gl *GzLog) globBackups(ext string) ([]string, error) {
+	dir := path.Dir(gl.basename)
+	base := path.Base(gl.basename)
+	sep := "."
+	if gl.Layout == Timestamp {
+		sep = "-"
+	}
+	prefix := base + sep
+
+	entries, err := gl.fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		if gl.Layout != Timestamp && name != base+ext {
+			// base+ext is basename.log(.gz), the first NumericSuffix backup once rotation
+			// has moved on to basename.1.log
+			middle := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+			if _, err := strconv.Atoi(middle); err != nil {
+				// not a basename.N.log(.gz) backup, e.g. an unrelated file sharing the prefix
+				continue
+			}
 		}
+		matches = append(matches, path.Join(dir, name))
+	}
+	return matches, nil
+}
 
-		if strings.HasSuffix(fn, ".gz") {
-			// file is an archive, moving on
+// compressPending archives any rotated-but-not-yet-archived backups, other than the
+// current file, that don't already have an archive sibling.
+// compressPending must be called with gl.mu held.
+func (gl *GzLog) compressPending() error {
+	matches, err := gl.globBackups(".log")
+	if err != nil {
+		return err
+	}
+	mode := gl.fileModeLocked()
+	c := gl.compressor()
+	for _, fn := range matches {
+		if fn == gl.filename || exists(gl.fs, fn+c.Extension()) {
 			continue
 		}
-		if fi.Size() < int64(maxSize) || maxSize == 0 {
-			// file isn't too big, use this
-			break
+		if err := compressFile(gl.fs, fn, mode, c); err != nil {
+			return err
 		}
-		if exists(fn + ".gz") {
-			// file is already archived
-		} else {
-			// file is too big but hasn't been archived yet. Archive it and move on
-			gzipFile(fn, mode)
+	}
+	return nil
+}
+
+// backupTime returns the time a backup should be considered to be from: for Timestamp
+// layout, the timestamp embedded in its filename; for NumericSuffix layout (which has no
+// such timestamp), its file modification time. Callers must hold gl.mu.
+func (gl *GzLog) backupTime(fn string) (time.Time, bool) {
+	if gl.Layout == Timestamp {
+		trimmed := strings.TrimSuffix(strings.TrimSuffix(fn, gl.compressor().Extension()), ".log")
+		trimmed = strings.TrimPrefix(trimmed, gl.basename+"-")
+		// rotateTimestamped appends "-N" to disambiguate backups created within the same
+		// millisecond; strip it and fold N into the parsed time as a sub-millisecond offset
+		// so colliding backups keep their creation order. The real timestamp's last hyphen
+		// is always followed by "SS.sss", which contains a ".", so strconv.Atoi only
+		// succeeds on a genuine collision suffix.
+		if i := strings.LastIndex(trimmed, "-"); i >= 0 {
+			if n, err := strconv.Atoi(trimmed[i+1:]); err == nil {
+				if t, err := time.Parse(timeLayout, trimmed[:i]); err == nil {
+					return t.Add(time.Duration(n)), true
+				}
+			}
+		}
+		if t, err := time.Parse(timeLayout, trimmed); err == nil {
+			return t, true
 		}
-		num++
-		fn = fmt.Sprintf(fnFmt, basename, num)
+		return time.Time{}, false
 	}
-	return fn, nil
+	fi, err := gl.fs.Stat(fn)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return fi.ModTime(), true
 }
 
-// GzLog is a logging tool for writing text to log files and automatically compressing and
-// rotating them if the current log file is larger than the maxSize before writing to it
-// to avoid having huge log files that can be tedious to use for debugging
-type GzLog struct {
-	basename     string
-	filename     string
-	file         *os.File
-	stat         os.FileInfo
-	maxSize      int64
-	externalFile bool
+// removeOldBackups enumerates archives, deletes any older than MaxAge, then deletes the
+// oldest of what remains so at most MaxBackups archives are kept. MaxAge == 0 and
+// MaxBackups == 0 each disable their respective pruning.
+func (gl *GzLog) removeOldBackups() {
+	if gl.MaxAge <= 0 && gl.MaxBackups <= 0 {
+		return
+	}
+	matches, err := gl.globBackups(".log" + gl.compressor().Extension())
+	if err != nil {
+		return
+	}
+	type backup struct {
+		fn string
+		t  time.Time
+	}
+	backups := make([]backup, 0, len(matches))
+	for _, fn := range matches {
+		if t, ok := gl.backupTime(fn); ok {
+			backups = append(backups, backup{fn, t})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].t.After(backups[j].t) })
+
+	if gl.MaxAge > 0 {
+		cutoff := time.Now().Add(-gl.MaxAge)
+		kept := backups[:0]
+		for _, b := range backups {
+			if b.t.Before(cutoff) {
+				gl.fs.Remove(b.fn)
+				continue
+			}
+			kept = append(kept, b)
+		}
+		backups = kept
+	}
+
+	if gl.MaxBackups > 0 {
+		for _, b := range backups[min(gl.MaxBackups, len(backups)):] {
+			gl.fs.Remove(b.fn)
+		}
+	}
 }
 
-// Close cleans up the log file unless the file was created elsewhere and imported with
-// ImportFile
-func (gl *GzLog) Close() error {
-	if gl.file == nil || gl.file == os.Stdout || gl.file == os.Stderr || gl.externalFile {
-		return nil
+func min(a, b int) int {
+	if a < b {
+		return a
 	}
-	return gl.file.Close()
+	return b
 }
 
 // Filename returns the filename of the current log file
 func (gl *GzLog) Filename(base bool) string {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
 	fn := gl.filename
 	if base {
 		fn = path.Base(fn)
@@ -133,6 +337,8 @@ func (gl *GzLog) ReadAllString() (string, error) {
 
 // ReadAll reads the contents of the current log file into a byte array and returns any errors
 func (gl *GzLog) ReadAll() ([]byte, error) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
 	size := gl.stat.Size()
 	ba := make([]byte, size)
 	_, err := gl.file.ReadAt(ba, 0)
@@ -142,41 +348,48 @@ func (gl *GzLog) ReadAll() ([]byte, error) {
 // Print behaves similarly to fmt.Print and log.Print
 func (gl *GzLog) Print(a ...interface{}) (string, error) {
 	str := fmt.Sprint(a...)
-	err := gl.writeStr(str, true)
+	err := gl.writeStr(str)
 	return str, err
 }
 
 // Printf behaves similarly to fmt.Printf and log.Printf
 func (gl *GzLog) Printf(format string, a ...interface{}) (string, error) {
 	str := fmt.Sprintf(format, a...)
-	err := gl.writeStr(str, true)
+	err := gl.writeStr(str)
 	return str, err
 }
 
 // Println behaves similarly to fmt.Println and log.Println
 func (gl *GzLog) Println(a ...interface{}) (string, error) {
 	str := fmt.Sprintln(a...)
-	err := gl.writeStr(str, true)
+	err := gl.writeStr(str)
 	return str, err
 }
 
-func (gl *GzLog) writeStr(str string, rotate bool) error {
-	var err error
-	if rotate {
-		if err = gl.rotate(); err != nil {
-			return err
-		}
-	}
+// writeStr trims str, prepends the gzlog timestamp prefix, and sends it through Write
+func (gl *GzLog) writeStr(str string) error {
 	str = strings.TrimSpace(str)
 	if str == "" {
 		return nil
 	}
-	if _, err = gl.file.WriteString(getPrefix() + str + "\n"); err != nil {
-		return err
-	}
+	_, err := gl.Write([]byte(getPrefix() + str + "\n"))
 	return err
 }
 
+// Write writes p to the current log file, rotating first if necessary, and satisfies
+// io.Writer (and, combined with Close, io.WriteCloser). Unlike Print/Printf/Println,
+// Write does not add a timestamp prefix, so *GzLog can be used as a drop-in destination
+// for log.SetOutput, slog.NewTextHandler, http.Server.ErrorLog, and similar APIs that
+// format their own timestamps.
+func (gl *GzLog) Write(p []byte) (int, error) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	if err := gl.rotate(); err != nil {
+		return 0, err
+	}
+	return gl.file.Write(p)
+}
+
 func (gl *GzLog) resetStat() error {
 	var err error
 	gl.stat, err = gl.file.Stat()
@@ -185,6 +398,13 @@ func (gl *GzLog) resetStat() error {
 
 // Size returns the file size of the current log file in bytes
 func (gl *GzLog) Size() int64 {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	return gl.sizeLocked()
+}
+
+// sizeLocked is Size's implementation, for callers that already hold gl.mu.
+func (gl *GzLog) sizeLocked() int64 {
 	if gl.file == os.Stdout || gl.file == os.Stderr {
 		return 0
 	}
@@ -194,40 +414,100 @@ func (gl *GzLog) Size() int64 {
 
 // FileMode returns the UNIX file mode (e.g. 0644)
 func (gl *GzLog) FileMode() os.FileMode {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	return gl.fileModeLocked()
+}
+
+// fileModeLocked is FileMode's implementation, for callers that already hold gl.mu.
+func (gl *GzLog) fileModeLocked() os.FileMode {
 	gl.resetStat()
 	return gl.stat.Mode()
 }
 
 // GZip compresses the log file in gz format and returns any errors
 func (gl *GzLog) GZip() error {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
 	if gl.file == os.Stdout || gl.file == os.Stderr {
 		return nil
 	}
 	gl.resetStat()
-	return gzipFile(gl.filename, gl.stat.Mode())
+	return compressFile(gl.fs, gl.filename, gl.stat.Mode(), gl.compressor())
 }
 
-// rotate checks to see if the file is too big and should be archived. If it is, it archives it
-// and opens a new one
+// rotate checks to see if the file is too big, or has been open for at least
+// RotateInterval, and should be archived. If so, it archives it and opens a new one.
+// Callers must hold gl.mu.
 func (gl *GzLog) rotate() error {
-	if gl.file == os.Stdout || gl.file == os.Stderr || gl.Size() < gl.maxSize || gl.maxSize == 0 {
+	if gl.file == os.Stdout || gl.file == os.Stderr {
+		return nil
+	}
+	dueToSize := gl.maxSize > 0 && gl.sizeLocked() >= gl.maxSize
+	dueToAge := gl.RotateInterval > 0 && time.Since(gl.openedAt) >= gl.RotateInterval
+	if !dueToSize && !dueToAge {
 		return nil
 	}
-	mode := gl.FileMode()
-	err := gl.Close()
+	mode := gl.fileModeLocked()
+	uid, gid, hasOwner := 0, 0, false
+	if _, ok := gl.fs.(OSFS); ok {
+		if fi, err := gl.fs.Stat(gl.filename); err == nil {
+			uid, gid, hasOwner = statOwner(fi)
+		}
+	}
+	err := gl.closeFile()
 	if err != nil {
 		return err
 	}
-	gl.filename, err = getSuitableFile(gl.basename, int(gl.maxSize), mode)
+	if gl.Layout == Timestamp {
+		gl.filename, err = gl.rotateTimestamped()
+	} else {
+		gl.filename, err = getSuitableFile(gl.fs, gl.basename, int(gl.maxSize), mode, gl.compressor())
+	}
 	if err != nil {
 		return err
 	}
-	gl.file, err = os.OpenFile(gl.filename, fileFlags, mode)
+	gl.file, err = gl.fs.OpenFile(gl.filename, fileFlags, mode)
 	if err != nil {
 		return err
 	}
-	gl.stat, err = gl.file.Stat()
-	return err
+	if gl.stat, err = gl.file.Stat(); err != nil {
+		return err
+	}
+	if _, ok := gl.fs.(OSFS); ok {
+		// os.OpenFile's mode is masked by umask, so chmod explicitly to get the
+		// preserved permissions.
+		if err := os.Chmod(gl.filename, mode); err != nil {
+			return err
+		}
+		if hasOwner {
+			if err := chown(gl.filename, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+	gl.openedAt = time.Now()
+	gl.maintenance()
+	return nil
+}
+
+// rotateTimestamped renames the just-closed active file to a basename-<timestamp>.log
+// backup and returns basename.log as the name for the fresh active file. Compression of
+// the backup is left to the maintenance worker, same as NumericSuffix layout.
+func (gl *GzLog) rotateTimestamped() (string, error) {
+	ts := time.Now().Format(timeLayout)
+	ext := gl.compressor().Extension()
+	backup := fmt.Sprintf(fnTimeFmt, gl.basename, ts)
+	for n := 1; exists(gl.fs, backup) || (ext != "" && exists(gl.fs, backup+ext)); n++ {
+		// timeLayout only has millisecond resolution, so a write burst under a small
+		// MaxSize can rotate twice within the same millisecond; disambiguate rather
+		// than let the second Rename silently clobber the first backup.
+		backup = fmt.Sprintf(fnTimeFmt, gl.basename, fmt.Sprintf("%s-%d", ts, n))
+	}
+	if err := gl.fs.Rename(gl.filename, backup); err != nil {
+		return "", err
+	}
+	return gl.basename + ".log", nil
 }
 
 // OpenFile opens the log in the specified log directory and basename, creating the file's
@@ -238,16 +518,28 @@ func (gl *GzLog) rotate() error {
 // If maxSize == 0, the log will never be rotated (i.e. it will essentially have no maximum
 // file size). This defeats the purpose of this package, but I figured I may as well include
 // it anyway
-func OpenFile(basename string, maxSize int, fileMode os.FileMode) (*GzLog, error) {
+//
+// layout selects the filename scheme used for rotated backups; pass NumericSuffix for the
+// original basename.N.log behavior, or Timestamp to name backups after the time they were
+// rotated (required for RotateInterval-based rotation).
+func OpenFile(basename string, maxSize int, fileMode os.FileMode, layout Layout) (*GzLog, error) {
+	return OpenFileFS(OSFS{}, basename, maxSize, fileMode, layout)
+}
+
+// OpenFileFS is like OpenFile, but against a caller-supplied FS instead of the local
+// filesystem. This is what lets GzLog run against afero.Fs, a testing/fstest-style
+// in-memory backend, or an S3/GCS-backed filesystem, and lets this package's own tests run
+// without touching disk.
+func OpenFileFS(fsys FS, basename string, maxSize int, fileMode os.FileMode, layout Layout) (*GzLog, error) {
 	if maxSize < 0 {
 		return nil, ErrInvalidSize
 	}
 	dir := path.Dir(basename)
-	err := os.Mkdir(dir, fileMode)
+	err := fsys.MkdirAll(dir, fileMode)
 	if err != nil && !os.IsExist(err) {
 		return nil, err
 	}
-	filename, err := getSuitableFile(basename, maxSize, fileMode)
+	filename, err := suitableFilename(fsys, basename, maxSize, fileMode, layout, GzipCompressor{})
 	if err != nil {
 		return nil, err
 	}
@@ -256,9 +548,12 @@ func OpenFile(basename string, maxSize int, fileMode os.FileMode) (*GzLog, error
 		filename:     filename,
 		maxSize:      int64(maxSize),
 		externalFile: false,
+		Layout:       layout,
+		openedAt:     time.Now(),
+		fs:           fsys,
 	}
 
-	gl.file, err = os.OpenFile(filename, fileFlags, fileMode)
+	gl.file, err = fsys.OpenFile(filename, fileFlags, fileMode)
 	if err != nil {
 		return gl, err
 	}
@@ -269,7 +564,7 @@ func OpenFile(basename string, maxSize int, fileMode os.FileMode) (*GzLog, error
 // ImportFile is similar to OpenFile, but it can use an already opened *os.File instead of
 // loading it in this package, including os.Stdout and os.Stderr. If Stdout or Stderr
 // are used as files, the log won't be rotated or compressed
-func ImportFile(file *os.File, basename string, maxSize int) (*GzLog, error) {
+func ImportFile(file *os.File, basename string, maxSize int, layout Layout) (*GzLog, error) {
 	if file == nil {
 		return nil, os.ErrClosed
 	}
@@ -285,7 +580,7 @@ func ImportFile(file *os.File, basename string, maxSize int) (*GzLog, error) {
 		maxSize = 0
 		basename = ""
 	} else {
-		filename, err = getSuitableFile(basename, maxSize, fi.Mode())
+		filename, err = suitableFilename(OSFS{}, basename, maxSize, fi.Mode(), layout, GzipCompressor{})
 		if err != nil {
 			return nil, err
 		}
@@ -297,6 +592,9 @@ func ImportFile(file *os.File, basename string, maxSize int) (*GzLog, error) {
 		maxSize:      int64(maxSize),
 		stat:         fi,
 		externalFile: true,
+		Layout:       layout,
+		openedAt:     time.Now(),
+		fs:           OSFS{},
 	}
 	return gl, nil
 }
@@ -1,8 +1,11 @@
 package gzlog
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -15,28 +18,23 @@ There really is a Linux, and these people are using it, but it is just a part of
 	maxSize = 1 * 1000 // 1 kb
 )
 
-// yes I know I'm technically not supposed to rely on I/O for unit tests, but who cares
+// These tests run against memFS, an in-memory FS, rather than the real disk.
 
-func populateLog(fn string, text string, t *testing.T) {
-	f, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+func populateLog(fsys FS, fn string, text string, t *testing.T) {
+	f, err := fsys.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		t.Fatal(err)
 		return
 	}
-	if _, err = f.WriteString(text); err != nil {
+	if _, err = f.Write([]byte(text)); err != nil {
 		t.Fatal(err)
 	}
 }
 
-func generateLogs(t *testing.T) {
-	err := os.Mkdir("logs", 0644)
-	if err != nil && !os.IsExist(err) {
-		t.Fatal(err)
-		return
-	}
-	populateLog("logs/gzlog.log", "blah blah blah blah blah", t)
-	populateLog("logs/gzlog.1.log", "blah blah blah blah blah", t)
-	populateLog("logs/gzlog.2.log", "blah blah blah blah blah", t)
+func generateLogs(fsys FS, t *testing.T) {
+	populateLog(fsys, "logs/gzlog.log", "blah blah blah blah blah", t)
+	populateLog(fsys, "logs/gzlog.1.log", "blah blah blah blah blah", t)
+	populateLog(fsys, "logs/gzlog.2.log", "blah blah blah blah blah", t)
 }
 
 func logTxt(gcl *GzLog, str string, t *testing.T) {
@@ -48,9 +46,10 @@ func logTxt(gcl *GzLog, str string, t *testing.T) {
 }
 
 func TestContinueLog(t *testing.T) {
-	generateLogs(t)
-	populateLog("logs/gzlog.3.log", "good", t)
-	fn, err := getSuitableFile("logs", "gzlog", 5, 0644)
+	fsys := newMemFS()
+	generateLogs(fsys, t)
+	populateLog(fsys, "logs/gzlog.3.log", "good", t)
+	fn, err := getSuitableFile(fsys, "logs/gzlog", 5, 0644, GzipCompressor{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -61,9 +60,10 @@ func TestContinueLog(t *testing.T) {
 }
 
 func TestCreateNewLog(t *testing.T) {
-	generateLogs(t)
-	populateLog("logs/gzlog.3.log", "too big", t)
-	fn, err := getSuitableFile("logs", "gzlog", 5, 0644)
+	fsys := newMemFS()
+	generateLogs(fsys, t)
+	populateLog(fsys, "logs/gzlog.3.log", "too big", t)
+	fn, err := getSuitableFile(fsys, "logs/gzlog", 5, 0644, GzipCompressor{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -74,8 +74,7 @@ func TestCreateNewLog(t *testing.T) {
 }
 
 func TestOpenLog(t *testing.T) {
-	dir := "logs"
-	gcl, err := OpenFile(dir, "gzlog-newlog", maxSize, 0644)
+	gcl, err := OpenFileFS(newMemFS(), "logs/gzlog-newlog", maxSize, 0644, NumericSuffix)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -92,7 +91,7 @@ func TestOpenLog(t *testing.T) {
 }
 
 func TestMaxSize(t *testing.T) {
-	gcl, err := OpenFile("logs", "gzlog-nomax", 0, 0644)
+	gcl, err := OpenFileFS(newMemFS(), "logs/gzlog-nomax", 0, 0644, NumericSuffix)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -104,7 +103,7 @@ func TestMaxSize(t *testing.T) {
 }
 
 func TestStdout(t *testing.T) {
-	gcl, err := UseFile(os.Stdout, "", 0)
+	gcl, err := ImportFile(os.Stdout, "", 0, NumericSuffix)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -116,7 +115,7 @@ func TestStdout(t *testing.T) {
 }
 
 func TestStderr(t *testing.T) {
-	gcl, err := UseFile(os.Stderr, "", 0)
+	gcl, err := ImportFile(os.Stderr, "", 0, NumericSuffix)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -126,3 +125,162 @@ func TestStderr(t *testing.T) {
 		logTxt(gcl, sentence, t)
 	}
 }
+
+func TestMaintenanceMaxBackups(t *testing.T) {
+	fsys := newMemFS()
+	gcl, err := OpenFileFS(fsys, "logs/mb", 10, 0644, NumericSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcl.MaxBackups = 2
+	for i := 0; i < 10; i++ {
+		logTxt(gcl, fmt.Sprintf("line number %d", i), t)
+	}
+	if err := gcl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close must be idempotent: a second call used to panic closing millCh twice.
+	if err := gcl.Close(); err != nil {
+		t.Fatalf("second Close should be a no-op, got %v", err)
+	}
+	matches, err := gcl.globBackups(".log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) > 2 {
+		t.Fatalf("expected at most 2 backups retained, got %d: %v", len(matches), matches)
+	}
+}
+
+func TestConcurrentWriteAndClose(t *testing.T) {
+	gcl, err := OpenFileFS(newMemFS(), "logs/concurrent", 50, 0644, NumericSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := gcl.Println(fmt.Sprintf("goroutine line %d", i)); err != nil {
+				t.Errorf("write %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := gcl.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCloseRaceWithFirstRotation exercises Close racing a concurrent Write that triggers
+// the first-ever rotation, which used to panic with "send on closed channel" because Close
+// touched millCh/done without the same gl.mu synchronization maintenance uses to create and
+// send on them.
+func TestCloseRaceWithFirstRotation(t *testing.T) {
+	gcl, err := OpenFileFS(newMemFS(), "logs/close-race", 10, 0644, NumericSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		gcl.Println("a line long enough to trigger rotation on the first write")
+	}()
+	if err := gcl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+// TestRotateTimestampedNoDataLoss writes enough lines under a small MaxSize to force many
+// Timestamp-layout rotations in a tight loop, the same write burst that used to collide
+// within a single millisecond and have the second fs.Rename clobber the first backup.
+func TestRotateTimestampedNoDataLoss(t *testing.T) {
+	gcl, err := OpenFileFS(newMemFS(), "logs/ts", 20, 0644, Timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gcl.Close()
+	const n = 20
+	for i := 0; i < n; i++ {
+		logTxt(gcl, fmt.Sprintf("line %d", i), t)
+	}
+	rc, err := gcl.TailReader(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) != n {
+		t.Fatalf("expected %d lines preserved across timestamped rotations, got %d: %v", n, len(lines), lines)
+	}
+	for i, line := range lines {
+		want := fmt.Sprintf("line %d", i)
+		if !strings.HasSuffix(line, want) {
+			t.Fatalf("expected line %d to be %q in order, got %q (full: %v)", i, want, line, lines)
+		}
+	}
+}
+
+// TestNoopCompressorGZipNoDuplicate guards against compressFile aliasing the read and
+// write of the currently-open file when Extension() == "", which used to duplicate the
+// line in place.
+func TestNoopCompressorGZipNoDuplicate(t *testing.T) {
+	gcl, err := OpenFileFS(newMemFS(), "logs/noop", 0, 0644, NumericSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcl.Compressor = NoopCompressor{}
+	defer gcl.Close()
+
+	logTxt(gcl, "hello", t)
+	before, err := gcl.ReadAllString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gcl.GZip(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := gcl.ReadAllString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != after {
+		t.Fatalf("GZip with NoopCompressor should leave the file unchanged, before=%q after=%q", before, after)
+	}
+}
+
+func TestNoopCompressorRotationSkipsGzip(t *testing.T) {
+	fsys := newMemFS()
+	gcl, err := OpenFileFS(fsys, "logs/noop-rot", 10, 0644, NumericSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcl.Compressor = NoopCompressor{}
+	defer gcl.Close()
+
+	for i := 0; i < 5; i++ {
+		logTxt(gcl, fmt.Sprintf("line %d", i), t)
+	}
+	matches, err := gcl.globBackups(".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) == 0 {
+		t.Fatal("expected at least one rotated backup")
+	}
+	archives, err := gcl.globBackups(".log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range archives {
+		if strings.HasSuffix(fn, ".gz") {
+			t.Fatalf("NoopCompressor should not produce .gz backups, got %s", fn)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package gzlog
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// memFS is a minimal in-memory FS used by this package's own tests so they don't have to
+// touch disk. It isn't meant as a general-purpose FS implementation -- callers who want an
+// in-memory backend of their own should reach for something like afero.NewMemMapFs or
+// testing/fstest.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memFileData
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*memFileData{}}
+}
+
+type memFileData struct {
+	name    string
+	mode    os.FileMode
+	data    []byte
+	modTime time.Time
+}
+
+func (m *memFS) Open(name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		f = &memFileData{name: name, mode: perm, modTime: time.Now()}
+		m.files[name] = f
+	}
+	if flag&os.O_TRUNC != 0 {
+		f.data = nil
+	}
+	return &memFile{fs: m, name: name}, nil
+}
+
+func (m *memFS) Create(name string) (File, error) {
+	return m.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+}
+
+func (m *memFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return memFileInfo{f}, nil
+}
+
+func (m *memFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[oldname]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(m.files, oldname)
+	f.name = newname
+	m.files[newname] = f
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) ReadDir(dir string) ([]os.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []os.DirEntry
+	for name, f := range m.files {
+		if path.Dir(name) == dir {
+			entries = append(entries, memDirEntry{f})
+		}
+	}
+	return entries, nil
+}
+
+func (m *memFS) MkdirAll(dir string, perm os.FileMode) error {
+	return nil
+}
+
+// memFile is the File handle returned by memFS.OpenFile/Create; writes append to the
+// backing memFileData and reads are served from it directly.
+type memFile struct {
+	fs   *memFS
+	name string
+}
+
+func (h *memFile) Write(p []byte) (int, error) {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	f := h.fs.files[h.name]
+	f.data = append(f.data, p...)
+	f.modTime = time.Now()
+	return len(p), nil
+}
+
+func (h *memFile) ReadAt(p []byte, off int64) (int, error) {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	f := h.fs.files[h.name]
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (h *memFile) Close() error { return nil }
+
+func (h *memFile) Stat() (os.FileInfo, error) {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	f, ok := h.fs.files[h.name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return memFileInfo{f}, nil
+}
+
+type memFileInfo struct{ f *memFileData }
+
+func (i memFileInfo) Name() string       { return path.Base(i.f.name) }
+func (i memFileInfo) Size() int64        { return int64(len(i.f.data)) }
+func (i memFileInfo) Mode() os.FileMode  { return i.f.mode }
+func (i memFileInfo) ModTime() time.Time { return i.f.modTime }
+func (i memFileInfo) IsDir() bool        { return false }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
+type memDirEntry struct{ f *memFileData }
+
+func (e memDirEntry) Name() string               { return path.Base(e.f.name) }
+func (e memDirEntry) IsDir() bool                { return false }
+func (e memDirEntry) Type() os.FileMode          { return e.f.mode.Type() }
+func (e memDirEntry) Info() (os.FileInfo, error) { return memFileInfo{e.f}, nil }
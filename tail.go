@@ -0,0 +1,211 @@
+package gzlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MakeDecoderFn wraps the raw bytes read from a backup (already gzip-decompressed, if it
+// was archived) before TailReader/RangeReader scan it for lines. The default, used when
+// GzLog.MakeDecoderFn is nil, treats r as gzlog's own newline-delimited plain text; set it
+// to support alternative on-disk formats, such as JSON lines or protobuf.
+type MakeDecoderFn func(r io.Reader) (io.Reader, error)
+
+// backupRef identifies a single backup file to read lines from.
+type backupRef struct {
+	fn       string
+	archived bool
+}
+
+// lineFilter controls which log lines collectLines keeps. A zero-value lineFilter (no
+// range) keeps everything.
+type lineFilter struct {
+	since, until time.Time
+	hasRange     bool
+}
+
+func (f lineFilter) matches(t time.Time) bool {
+	if !f.hasRange {
+		return true
+	}
+	return !t.Before(f.since) && t.Before(f.until)
+}
+
+// overlaps reports whether the [first, last] span of an archive could contain any line
+// the filter would keep, so whole archives outside the window can be skipped unread.
+func (f lineFilter) overlaps(first, last time.Time) bool {
+	if !f.hasRange {
+		return true
+	}
+	return !last.Before(f.since) && first.Before(f.until)
+}
+
+// TailReader returns a reader over the last n log lines, read across the active log file
+// and its rotated backups (decompressing .gz archives on the fly as needed).
+func (gl *GzLog) TailReader(n int) (io.ReadCloser, error) {
+	lines, err := gl.collectLines(lineFilter{}, n)
+	if err != nil {
+		return nil, err
+	}
+	return linesReadCloser(lines), nil
+}
+
+// RangeReader returns a reader over log lines whose timestamp falls in [since, until),
+// read across the active log file and its rotated backups. Lines without a logTimeFmt
+// timestamp prefix are always included. Archives whose first/last
+// timestamps (recorded in their gzip header when they were written) fall entirely outside
+// the window are skipped without being decompressed.
+func (gl *GzLog) RangeReader(since, until time.Time) (io.ReadCloser, error) {
+	lines, err := gl.collectLines(lineFilter{since: since, until: until, hasRange: true}, 0)
+	if err != nil {
+		return nil, err
+	}
+	return linesReadCloser(lines), nil
+}
+
+// collectLines gathers lines matching filter across backups, newest to oldest, stopping
+// early once limit lines have been collected (limit <= 0 means no limit). The result is
+// returned in chronological order. It holds gl.mu for its full duration, the same
+// discipline millRunOnce uses, since it reads gl.filename/gl.fs/gl.Compressor/
+// gl.MakeDecoderFn, all of which rotate mutates under gl.mu.
+func (gl *GzLog) collectLines(filter lineFilter, limit int) ([]string, error) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+
+	backups, err := gl.orderedBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, b := range backups {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		if b.archived && filter.hasRange {
+			if first, last, ok := gl.archiveRange(b.fn); ok && !filter.overlaps(first, last) {
+				continue
+			}
+		}
+		lines, err := gl.readBackupLines(b.fn, b.archived)
+		if err != nil {
+			return nil, err
+		}
+		var matched []string
+		for _, line := range lines {
+			if t, ok := parseLineTime(line); ok && !filter.matches(t) {
+				continue
+			}
+			matched = append(matched, line)
+		}
+		result = append(matched, result...)
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, nil
+}
+
+// orderedBackups lists the active log file followed by its rotated backups, newest to
+// oldest. Callers must hold gl.mu.
+func (gl *GzLog) orderedBackups() ([]backupRef, error) {
+	plain, err := gl.globBackups(".log")
+	if err != nil {
+		return nil, err
+	}
+	ext := gl.compressor().Extension()
+	var archives []string
+	if ext != "" {
+		archives, err = gl.globBackups(".log" + ext)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	type dated struct {
+		backupRef
+		t time.Time
+	}
+	var dateds []dated
+	for _, fn := range plain {
+		if fn == gl.filename {
+			continue
+		}
+		if ext != "" && exists(gl.fs, fn+ext) {
+			// already archived; read it from the archive below instead of twice
+			continue
+		}
+		t, _ := gl.backupTime(fn)
+		dateds = append(dateds, dated{backupRef{fn, false}, t})
+	}
+	for _, fn := range archives {
+		t, _ := gl.backupTime(fn)
+		dateds = append(dateds, dated{backupRef{fn, true}, t})
+	}
+	sort.Slice(dateds, func(i, j int) bool { return dateds[i].t.After(dateds[j].t) })
+
+	refs := make([]backupRef, 0, len(dateds)+1)
+	refs = append(refs, backupRef{gl.filename, false})
+	for _, d := range dateds {
+		refs = append(refs, d.backupRef)
+	}
+	return refs, nil
+}
+
+// archiveRange reads just the gzip header of an archive to recover the first/last log
+// line timestamps embedded by compressFile, without decompressing the body. Callers must
+// hold gl.mu.
+func (gl *GzLog) archiveRange(fn string) (first, last time.Time, ok bool) {
+	rc, err := gl.fs.Open(fn)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	defer rc.Close()
+	gzr, err := gzip.NewReader(rc)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	defer gzr.Close()
+	return parseArchiveComment(gzr.Header.Comment)
+}
+
+// readBackupLines reads and, if needed, decompresses fn, runs it through MakeDecoderFn if
+// one is set, and splits the result into lines. Callers must hold gl.mu.
+func (gl *GzLog) readBackupLines(fn string, archived bool) ([]string, error) {
+	rc, err := gl.fs.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if archived {
+		gzr, err := gzip.NewReader(rc)
+		if err != nil {
+			return nil, err
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+	if gl.MakeDecoderFn != nil {
+		r, err = gl.MakeDecoderFn(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var lines []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines, sc.Err()
+}
+
+func linesReadCloser(lines []string) io.ReadCloser {
+	return io.NopCloser(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+}